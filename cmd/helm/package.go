@@ -44,12 +44,13 @@ Versioned chart archives are used by Helm package repositories.
 `
 
 type packageCmd struct {
-	save    bool
-	sign    bool
-	path    string
-	key     string
-	keyring string
-	out     io.Writer
+	save       bool
+	sign       bool
+	signImages bool
+	path       string
+	keys       []string
+	keyring    string
+	out        io.Writer
 }
 
 func newPackageCmd(client helm.Interface, out io.Writer) *cobra.Command {
@@ -66,13 +67,16 @@ func newPackageCmd(client helm.Interface, out io.Writer) *cobra.Command {
 			}
 			pkg.path = args[0]
 			if pkg.sign {
-				if pkg.key == "" {
+				if len(pkg.keys) == 0 {
 					return errors.New("--key is required for signing a package")
 				}
 				if pkg.keyring == "" {
 					return errors.New("--keyring is required for signing a package")
 				}
 			}
+			if pkg.signImages && !pkg.sign {
+				return errors.New("--sign-images requires --sign")
+			}
 			return pkg.run(cmd, args)
 		},
 	}
@@ -80,7 +84,8 @@ func newPackageCmd(client helm.Interface, out io.Writer) *cobra.Command {
 	f := cmd.Flags()
 	f.BoolVar(&pkg.save, "save", true, "save packaged chart to local chart repository")
 	f.BoolVar(&pkg.sign, "sign", false, "use a PGP private key to sign this package")
-	f.StringVar(&pkg.key, "key", "", "the name of the key to use when signing. Used if --sign is true.")
+	f.BoolVar(&pkg.signImages, "sign-images", false, "also resolve and sign the registry digests of images referenced by this chart. Requires --sign. Only supports charts whose image fields are static; a templated image field (e.g. using .Values) fails with an error, since signing happens before the chart is rendered against a release.")
+	f.StringArrayVar(&pkg.keys, "key", nil, "the name of the key to use when signing. Used if --sign is true. May be repeated to add multiple signers.")
 	f.StringVar(&pkg.keyring, "keyring", defaultKeyring(), "the location of a public keyring")
 
 	return cmd
@@ -129,20 +134,44 @@ func (p *packageCmd) run(cmd *cobra.Command, args []string) error {
 }
 
 func (p *packageCmd) clearsign(filename string) error {
-	// Load keyring
-	signer, err := provenance.NewFromKeyring(p.keyring, p.key)
+	// The first key produces the initial provenance file; any additional
+	// keys append their own signature block to it (see Signatory.AddSignature).
+	signer, err := provenance.NewFromKeyring(p.keyring, p.keys[0])
 	if err != nil {
 		return err
 	}
 
+	if p.signImages {
+		signer.ImageResolver = &provenance.RegistryResolver{}
+	}
+
 	sig, err := signer.ClearSign(filename)
 	if err != nil {
 		return err
 	}
 
+	provfile := filename + ".prov"
+	if err := ioutil.WriteFile(provfile, []byte(sig), 0755); err != nil {
+		return err
+	}
+
+	for _, key := range p.keys[1:] {
+		cosigner, err := provenance.NewFromKeyring(p.keyring, key)
+		if err != nil {
+			return err
+		}
+		sig, err = cosigner.AddSignature(provfile)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(provfile, []byte(sig), 0755); err != nil {
+			return err
+		}
+	}
+
 	if flagDebug {
 		fmt.Fprintln(p.out, sig)
 	}
 
-	return ioutil.WriteFile(filename+".prov", []byte(sig), 0755)
+	return nil
 }
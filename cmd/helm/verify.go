@@ -0,0 +1,123 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"k8s.io/helm/pkg/provenance"
+)
+
+const verifyDesc = `
+This command verifies that a chart at a given path has been signed and that
+the signature matches the provenance of the chart.
+
+This can be used in conjunction with helm package --sign to verify a chart
+before it is installed.
+`
+
+type verifyCmd struct {
+	keyring        string
+	chartfile      string
+	keyservers     []string
+	keyserverTrust string
+	allowExpired   bool
+	verifyImages   bool
+	out            io.Writer
+}
+
+func newVerifyCmd(out io.Writer) *cobra.Command {
+	vc := &verifyCmd{out: out}
+
+	cmd := &cobra.Command{
+		Use:   "verify [CHART]",
+		Short: "verify that a chart at the given path has been signed and is valid",
+		Long:  verifyDesc,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return fmt.Errorf("a path to a package file is required")
+			}
+			vc.chartfile = args[0]
+			return vc.run()
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&vc.keyring, "keyring", defaultKeyring(), "keyring containing public keys")
+	f.StringArrayVar(&vc.keyservers, "keyserver", nil, "HKP(S) keyserver(s) to consult for signer keys missing from the keyring. May be repeated.")
+	f.StringVar(&vc.keyserverTrust, "keyserver-trust", "tofu", `trust mode for keys fetched from a keyserver: "tofu" (trust on first use) or "strict" (refuse unseen keys)`)
+	f.BoolVar(&vc.allowExpired, "verify-allow-expired", false, "accept signatures made with a key that had already expired at signing time, or with a revoked key, instead of rejecting them")
+	f.BoolVar(&vc.verifyImages, "verify-images", false, "also re-resolve and check the registry digest of every image signed alongside the chart (see --sign-images in 'helm package')")
+
+	return cmd
+}
+
+func (v *verifyCmd) run() error {
+	signer, err := v.signatory()
+	if err != nil {
+		return err
+	}
+
+	if v.allowExpired {
+		signer.VerifyOptions.AllowExpiredKey = true
+		signer.VerifyOptions.AllowRevokedKey = true
+	}
+
+	if v.verifyImages {
+		signer.VerifyImages = true
+		signer.ImageResolver = &provenance.RegistryResolver{}
+	}
+
+	_, err = signer.Verify(v.chartfile, v.chartfile+".prov")
+	return err
+}
+
+// signatory builds the Signatory used for verification, wiring in a
+// keyserver-backed keyring when --keyserver was given.
+func (v *verifyCmd) signatory() (*provenance.Signatory, error) {
+	if len(v.keyservers) == 0 {
+		return provenance.NewFromKeyring(v.keyring, "")
+	}
+
+	trust := provenance.TrustTOFU
+	if v.keyserverTrust == "strict" {
+		trust = provenance.TrustStrict
+	}
+
+	return provenance.NewWithKeyserver(
+		v.keyring,
+		v.keyservers,
+		provenance.WithCacheDir(keyserverCacheDir()),
+		provenance.WithTrust(trust),
+	)
+}
+
+// keyserverCacheDir returns the directory that keys fetched from a keyserver
+// are pinned to, under $HELM_HOME, so repeat verifications don't need the
+// network.
+func keyserverCacheDir() string {
+	home := os.Getenv("HELM_HOME")
+	if home == "" {
+		home = filepath.Join(os.Getenv("HOME"), ".helm")
+	}
+	return filepath.Join(home, "keyservers")
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provenance
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/openpgp/clearsign"
+
+	hapi "k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+// EnvelopeAPIVersion identifies the current provenance envelope schema.
+const EnvelopeAPIVersion = "helm.sh/provenance/v2"
+
+// Envelope is the structured, self-describing payload that gets clearsigned
+// into a .prov file.
+//
+// It replaces the earlier format, which concatenated a YAML-encoded
+// chart.Metadata document and a YAML-encoded SumCollection document,
+// separated by a literal "\n...\n" because "---\n" isn't legal inside a
+// clearsign block. An Envelope is instead a single canonical JSON document,
+// identified by APIVersion, with named sections that can grow over time
+// (e.g. Attestations, Annotations) without another such format hack.
+// encoding/json emits struct fields in declaration order and map keys in
+// sorted order, so two
+// Envelopes with the same content always produce byte-identical signed
+// payloads.
+type Envelope struct {
+	// APIVersion identifies the schema this envelope follows. parseMessageBlock
+	// dispatches on this field; it is always EnvelopeAPIVersion for envelopes
+	// produced by this version of Helm.
+	APIVersion string `json:"apiVersion"`
+	// Metadata is the signed chart's Chart.yaml contents.
+	Metadata *hapi.Metadata `json:"metadata"`
+	// Files holds a SHA sum, prefixed with its scheme, for every signed file.
+	Files map[string]string `json:"files"`
+	// Images holds a registry content digest, keyed by "IMAGE:TAG", for every
+	// container image signed alongside the chart. See SumCollection.Images.
+	Images map[string]string `json:"images,omitempty"`
+	// Attestations carries arbitrary attested facts (e.g. from a CI system)
+	// that a signer wants bound to this signature.
+	Attestations map[string]string `json:"attestations,omitempty"`
+	// Annotations carries arbitrary signer-supplied key/value pairs that
+	// don't need any special verification handling.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// SignEnvelope clearsigns env directly, rather than building one from a
+// chart archive as ClearSign does. This lets callers (e.g. the `helm
+// package` command, or a CI pipeline) attach attestations or annotations
+// beyond the standard files/images sections without further format changes.
+func (s *Signatory) SignEnvelope(env *Envelope) (string, error) {
+	key, err := s.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	if env.APIVersion == "" {
+		env.APIVersion = EnvelopeAPIVersion
+	}
+
+	b, err := encodeEnvelope(env)
+	if err != nil {
+		return "", err
+	}
+
+	out := bytes.NewBuffer(nil)
+	w, err := clearsign.Encode(out, key, &defaultPGPConfig)
+	if err != nil {
+		return "", err
+	}
+	_, err = b.WriteTo(w)
+	w.Close()
+	return out.String(), err
+}
+
+// VerifyEnvelope behaves like Verify, but also returns the full Envelope
+// that was signed, giving callers access to any attestations or annotations
+// carried alongside the standard file and image sums.
+//
+// It returns an error if sigpath's payload isn't a v2 envelope; legacy
+// single-block provenance files should be checked with Verify instead.
+func (s *Signatory) VerifyEnvelope(chartpath, sigpath string) (*Verification, *Envelope, error) {
+	if err := checkNotDir(chartpath, sigpath); err != nil {
+		return nil, nil, err
+	}
+
+	sig, err := s.decodeSignature(sigpath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode signature: %s", err)
+	}
+
+	env, err := decodeEnvelope(sig.Plaintext)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s does not contain a v2 provenance envelope: %s", sigpath, err)
+	}
+
+	ver, err := s.verifyBlock(chartpath, sig)
+	return ver, env, err
+}
+
+// encodeEnvelope serializes env to its canonical, signable form.
+func encodeEnvelope(env *Envelope) (*bytes.Buffer, error) {
+	data, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewBuffer(data), nil
+}
+
+// decodeEnvelope parses data as a v2 Envelope, failing if it doesn't carry a
+// recognized apiVersion. This is how parseMessageBlock tells a v2 payload
+// from the legacy v1 format.
+func decodeEnvelope(data []byte) (*Envelope, error) {
+	env := &Envelope{}
+	if err := json.Unmarshal(data, env); err != nil {
+		return nil, err
+	}
+	if env.APIVersion == "" {
+		return nil, errors.New("payload has no apiVersion")
+	}
+	if env.APIVersion != EnvelopeAPIVersion {
+		return nil, fmt.Errorf("unsupported apiVersion %q", env.APIVersion)
+	}
+	return env, nil
+}
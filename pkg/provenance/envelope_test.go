@@ -0,0 +1,186 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provenance
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+
+	hapi "k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+func TestEncodeDecodeEnvelopeRoundTrip(t *testing.T) {
+	env := &Envelope{
+		APIVersion: EnvelopeAPIVersion,
+		Metadata:   &hapi.Metadata{Name: "test", Version: "0.1.0"},
+		Files:      map[string]string{"test-0.1.0.tgz": "sha256:deadbeef"},
+		Images:     map[string]string{"nginx:1.14": "sha256:beefdead"},
+	}
+
+	buf, err := encodeEnvelope(env)
+	if err != nil {
+		t.Fatalf("encodeEnvelope: %s", err)
+	}
+
+	decoded, err := decodeEnvelope(buf.Bytes())
+	if err != nil {
+		t.Fatalf("decodeEnvelope: %s", err)
+	}
+
+	if decoded.APIVersion != env.APIVersion {
+		t.Errorf("APIVersion = %q, want %q", decoded.APIVersion, env.APIVersion)
+	}
+	if decoded.Metadata.Name != env.Metadata.Name || decoded.Metadata.Version != env.Metadata.Version {
+		t.Errorf("Metadata = %+v, want %+v", decoded.Metadata, env.Metadata)
+	}
+	if decoded.Files["test-0.1.0.tgz"] != "sha256:deadbeef" {
+		t.Errorf("Files = %v, want sha256:deadbeef", decoded.Files)
+	}
+	if decoded.Images["nginx:1.14"] != "sha256:beefdead" {
+		t.Errorf("Images = %v, want sha256:beefdead", decoded.Images)
+	}
+}
+
+func TestDecodeEnvelopeRejectsMissingAPIVersion(t *testing.T) {
+	if _, err := decodeEnvelope([]byte(`{"metadata":{"name":"test"}}`)); err == nil {
+		t.Error("decodeEnvelope with no apiVersion returned no error, want one")
+	}
+}
+
+func TestSignVerifyEnvelopeRoundTrip(t *testing.T) {
+	alice := newTestEntity(t, "alice")
+	chartpath, env := newTestChart(t, []byte("fake chart contents"))
+
+	signer := &Signatory{Entity: alice}
+	doc, err := signer.SignEnvelope(env)
+	if err != nil {
+		t.Fatalf("SignEnvelope: %s", err)
+	}
+
+	provfile := chartpath + ".prov"
+	if err := ioutil.WriteFile(provfile, []byte(doc), 0644); err != nil {
+		t.Fatalf("writing provenance file: %s", err)
+	}
+
+	verifier := &Signatory{KeyRing: openpgp.EntityList{alice}}
+	ver, gotEnv, err := verifier.VerifyEnvelope(chartpath, provfile)
+	if err != nil {
+		t.Fatalf("VerifyEnvelope: %s", err)
+	}
+	if ver.SignedBy != alice {
+		t.Errorf("VerifyEnvelope signed by %v, want alice", ver.SignedBy)
+	}
+	if gotEnv.Metadata.Name != env.Metadata.Name {
+		t.Errorf("VerifyEnvelope Metadata.Name = %q, want %q", gotEnv.Metadata.Name, env.Metadata.Name)
+	}
+}
+
+func TestParseMessageBlockV2Envelope(t *testing.T) {
+	env := &Envelope{
+		APIVersion: EnvelopeAPIVersion,
+		Metadata:   &hapi.Metadata{Name: "test", Version: "0.1.0"},
+		Files:      map[string]string{"test-0.1.0.tgz": "sha256:deadbeef"},
+	}
+	buf, err := encodeEnvelope(env)
+	if err != nil {
+		t.Fatalf("encodeEnvelope: %s", err)
+	}
+
+	md, sc, err := parseMessageBlock(buf.Bytes())
+	if err != nil {
+		t.Fatalf("parseMessageBlock (v2): %s", err)
+	}
+	if md.Name != "test" {
+		t.Errorf("parseMessageBlock (v2) Metadata.Name = %q, want %q", md.Name, "test")
+	}
+	if sc.Files["test-0.1.0.tgz"] != "sha256:deadbeef" {
+		t.Errorf("parseMessageBlock (v2) Files = %v, want sha256:deadbeef", sc.Files)
+	}
+}
+
+// legacyV1MessageBlock builds a pre-Envelope provenance payload: a
+// YAML-encoded chart.Metadata document and a YAML-encoded SumCollection
+// document, separated by the literal "\n...\n" that parseMessageBlock's v1
+// fallback expects. See parseMessageBlock's doc comment.
+func legacyV1MessageBlock(t *testing.T, md *hapi.Metadata, sc *SumCollection) []byte {
+	t.Helper()
+	mdYAML, err := yaml.Marshal(md)
+	if err != nil {
+		t.Fatalf("marshaling legacy metadata: %s", err)
+	}
+	scYAML, err := yaml.Marshal(sc)
+	if err != nil {
+		t.Fatalf("marshaling legacy sum collection: %s", err)
+	}
+	return bytes.Join([][]byte{mdYAML, scYAML}, []byte("\n...\n"))
+}
+
+func TestParseMessageBlockV1Legacy(t *testing.T) {
+	md := &hapi.Metadata{Name: "test", Version: "0.1.0"}
+	sc := &SumCollection{Files: map[string]string{"test-0.1.0.tgz": "sha256:deadbeef"}}
+
+	gotMD, gotSC, err := parseMessageBlock(legacyV1MessageBlock(t, md, sc))
+	if err != nil {
+		t.Fatalf("parseMessageBlock (v1): %s", err)
+	}
+	if gotMD.Name != md.Name {
+		t.Errorf("parseMessageBlock (v1) Metadata.Name = %q, want %q", gotMD.Name, md.Name)
+	}
+	if gotSC.Files["test-0.1.0.tgz"] != "sha256:deadbeef" {
+		t.Errorf("parseMessageBlock (v1) Files = %v, want sha256:deadbeef", gotSC.Files)
+	}
+}
+
+// TestVerifyLegacyV1ProvenanceFile locks in the backward-compat contract
+// Envelope exists to guarantee: a .prov file signed before v2 was introduced
+// must still verify against today's Verify.
+func TestVerifyLegacyV1ProvenanceFile(t *testing.T) {
+	alice := newTestEntity(t, "alice")
+	chartpath, env := newTestChart(t, []byte("fake chart contents"))
+
+	legacy := legacyV1MessageBlock(t, env.Metadata, &SumCollection{Files: env.Files})
+
+	out := bytes.NewBuffer(nil)
+	w, err := clearsign.Encode(out, alice.PrivateKey, &defaultPGPConfig)
+	if err != nil {
+		t.Fatalf("clearsign.Encode: %s", err)
+	}
+	if _, err := w.Write(legacy); err != nil {
+		t.Fatalf("writing legacy payload: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("closing clearsign writer: %s", err)
+	}
+
+	provfile := chartpath + ".prov"
+	if err := ioutil.WriteFile(provfile, []byte(out.String()), 0644); err != nil {
+		t.Fatalf("writing provenance file: %s", err)
+	}
+
+	verifier := &Signatory{KeyRing: openpgp.EntityList{alice}}
+	ver, err := verifier.Verify(chartpath, provfile)
+	if err != nil {
+		t.Fatalf("Verify (legacy v1 payload): %s", err)
+	}
+	if ver.SignedBy != alice {
+		t.Errorf("Verify (legacy v1) signed by %v, want alice", ver.SignedBy)
+	}
+}
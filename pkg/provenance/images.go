@@ -0,0 +1,176 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provenance
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// imageRefPattern matches `image: <ref>` fields in a chart's rendered or raw
+// Kubernetes manifests, whether written as a bare mapping field:
+//
+//	image: nginx:1.14
+//
+// or as a YAML sequence item, the common shape for a Pod's containers list:
+//
+//   - image: nginx:1.14
+//
+// A value holding Helm template actions, with or without spaces inside the
+// braces (e.g. "{{ .Values.image.repository }}:{{ .Values.image.tag }}"),
+// is matched whole, so callers can detect and reject it.
+var imageRefPattern = regexp.MustCompile(`(?m)^\s*(?:-\s*)?image:\s*"?((?:[^"'\s{]|\{\{[^}]*\}\})+)"?\s*$`)
+
+// ImageResolver resolves a container image reference to its registry content
+// digest.
+//
+// The default implementation, RegistryResolver, talks to a real Docker
+// Registry v2 API. Air-gapped environments can supply their own
+// implementation (for example, one backed by a local image cache or mirror)
+// anywhere a Signatory accepts an ImageResolver.
+type ImageResolver interface {
+	// ResolveDigest returns the current content digest (e.g. "sha256:...")
+	// for the image reference ref ("name:tag").
+	ResolveDigest(ref string) (string, error)
+}
+
+// RegistryResolver resolves image digests using the Docker Registry v2 HTTP API.
+//
+// It issues `HEAD /v2/<name>/manifests/<tag>` and reads the resulting
+// `Docker-Content-Digest` header, which registries set for both `sha256` and
+// `sha512` manifests.
+type RegistryResolver struct {
+	// Client is the HTTP client used to talk to registries. If nil, http.DefaultClient is used.
+	Client *http.Client
+	// Scheme is the URL scheme used to reach a registry host. Defaults to "https".
+	Scheme string
+}
+
+// ResolveDigest implements ImageResolver.
+func (r *RegistryResolver) ResolveDigest(ref string) (string, error) {
+	host, name, tag, err := splitImageRef(ref)
+	if err != nil {
+		return "", err
+	}
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	scheme := r.Scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+
+	url := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", scheme, host, name, tag)
+	req, err := http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", strings.Join([]string{
+		"application/vnd.docker.distribution.manifest.v2+json",
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.oci.image.manifest.v1+json",
+	}, ", "))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry %s returned %s for %s", host, resp.Status, ref)
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("registry %s did not return a Docker-Content-Digest for %s", host, ref)
+	}
+	if !strings.HasPrefix(digest, "sha256:") && !strings.HasPrefix(digest, "sha512:") {
+		return "", fmt.Errorf("unsupported digest algorithm in %q", digest)
+	}
+	return digest, nil
+}
+
+// splitImageRef splits a "[host/]name:tag" reference into a registry host,
+// repository name, and tag. References with no host default to
+// "registry-1.docker.io", matching Docker's own convention.
+func splitImageRef(ref string) (host, name, tag string, err error) {
+	repo := ref
+	tag = "latest"
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		repo, tag = ref[:i], ref[i+1:]
+	}
+
+	parts := strings.SplitN(repo, "/", 2)
+	if len(parts) == 2 && (strings.Contains(parts[0], ".") || strings.Contains(parts[0], ":") || parts[0] == "localhost") {
+		return parts[0], parts[1], tag, nil
+	}
+
+	if repo == "" {
+		return "", "", "", fmt.Errorf("invalid image reference %q", ref)
+	}
+	if !strings.Contains(repo, "/") {
+		repo = "library/" + repo
+	}
+	return "registry-1.docker.io", repo, tag, nil
+}
+
+// ExtractImages scans a chart's manifests (keyed by template name) for
+// `image:` references and returns the distinct set, in the order first seen.
+// Within one manifest that is document order; manifests are visited in map
+// iteration order, so the order across manifests is not defined.
+func ExtractImages(manifests map[string]string) []string {
+	seen := map[string]bool{}
+	var images []string
+	for _, manifest := range manifests {
+		for _, match := range imageRefPattern.FindAllStringSubmatch(manifest, -1) {
+			ref := match[1]
+			if !seen[ref] {
+				seen[ref] = true
+				images = append(images, ref)
+			}
+		}
+	}
+	return images
+}
+
+// resolveImageSums resolves the registry digest for every image reference
+// found in manifests and returns them keyed as "IMAGE:TAG", ready to be
+// stored in SumCollection.Images.
+//
+// manifests holds raw, unrendered template source (see templateManifests),
+// so a chart that templates its image field (the common case) yields a ref
+// that still contains the literal Helm expression. Resolving that against a
+// registry would only fail with a confusing not-found error, so it's
+// rejected here with an actionable one instead.
+func resolveImageSums(resolver ImageResolver, manifests map[string]string) (map[string]string, error) {
+	sums := map[string]string{}
+	for _, ref := range ExtractImages(manifests) {
+		if strings.Contains(ref, "{{") {
+			return nil, fmt.Errorf("image reference %q is an unrendered template expression, not a real image ref; --sign-images only supports charts with a static image field, since signing happens before the chart is rendered against a release. Pre-render and repack the chart to sign resolved digests", ref)
+		}
+		digest, err := resolver.ResolveDigest(ref)
+		if err != nil {
+			return nil, fmt.Errorf("resolving digest for %s: %s", ref, err)
+		}
+		sums[ref] = digest
+	}
+	return sums, nil
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provenance
+
+import (
+	"strings"
+	"testing"
+)
+
+type stubResolver struct {
+	digest string
+}
+
+func (r *stubResolver) ResolveDigest(ref string) (string, error) {
+	return r.digest, nil
+}
+
+func TestExtractImagesMatchesListItemSyntax(t *testing.T) {
+	manifests := map[string]string{
+		"templates/deployment.yaml": `
+containers:
+  - name: nginx
+    image: nginx:1.14
+  - name: sidecar
+    image: "envoyproxy/envoy:v1.20"
+`,
+	}
+	images := ExtractImages(manifests)
+	if len(images) != 2 || images[0] != "nginx:1.14" || images[1] != "envoyproxy/envoy:v1.20" {
+		t.Errorf("ExtractImages() = %v, want [nginx:1.14 envoyproxy/envoy:v1.20]", images)
+	}
+}
+
+func TestResolveImageSumsRejectsUnrenderedTemplateRefs(t *testing.T) {
+	manifests := map[string]string{
+		"templates/deployment.yaml": `image: "{{.Values.image.repository}}:{{.Values.image.tag}}"`,
+	}
+	_, err := resolveImageSums(&stubResolver{digest: "sha256:deadbeef"}, manifests)
+	if err == nil {
+		t.Fatal("resolveImageSums() returned no error, want one (unrendered template expression)")
+	}
+	if !strings.Contains(err.Error(), "unrendered template expression") {
+		t.Errorf("resolveImageSums() error = %q, want it to explain the unrendered template expression", err)
+	}
+}
+
+func TestResolveImageSumsResolvesStaticRefs(t *testing.T) {
+	manifests := map[string]string{
+		"templates/deployment.yaml": "image: nginx:1.21",
+	}
+	sums, err := resolveImageSums(&stubResolver{digest: "sha256:deadbeef"}, manifests)
+	if err != nil {
+		t.Fatalf("resolveImageSums() returned error: %s", err)
+	}
+	if sums["nginx:1.21"] != "sha256:deadbeef" {
+		t.Errorf("resolveImageSums() = %v, want nginx:1.21 resolved to sha256:deadbeef", sums)
+	}
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provenance
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// KeyserverTrust controls how a keyserverKeyRing treats a key it had to
+// fetch from a keyserver, as opposed to one already present in the local
+// keyring.
+type KeyserverTrust int
+
+const (
+	// TrustTOFU ("trust on first use") accepts any key fetched from a
+	// keyserver and, if a cache directory is configured, pins it to disk so
+	// later lookups don't need the network.
+	TrustTOFU KeyserverTrust = iota
+	// TrustStrict refuses to use any key that isn't already in the local
+	// keyring; keyserver lookups are never attempted.
+	TrustStrict
+)
+
+// Option configures a Signatory constructed by NewWithKeyserver.
+type Option func(*keyserverKeyRing)
+
+// WithCacheDir sets the directory newly-fetched keys are pinned to, normally
+// "$HELM_HOME/keyservers/". If unset, fetched keys are kept in memory only.
+func WithCacheDir(dir string) Option {
+	return func(k *keyserverKeyRing) { k.cacheDir = dir }
+}
+
+// WithTrust sets the trust mode applied to keys that must be fetched from a
+// keyserver. The default is TrustTOFU.
+func WithTrust(trust KeyserverTrust) Option {
+	return func(k *keyserverKeyRing) { k.trust = trust }
+}
+
+// WithTLSConfig sets the TLS configuration used for HKPS (keyserver-over-TLS)
+// requests, letting callers supply a custom CA for internal keyservers.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(k *keyserverKeyRing) { k.tlsConfig = cfg }
+}
+
+// NewWithKeyserver constructs a Signatory whose KeyRing lazily fetches
+// signer keys it doesn't already have from one or more HKP(S) keyservers.
+//
+// keyringfile seeds the local keyring as usual; any key found there is
+// always trusted and a keyserver is never consulted for it. When
+// signature check encounters an issuer it doesn't recognize, it looks the
+// key up against servers in order, via
+// `GET /pks/lookup?op=get&options=mr&search=0x<keyid>`, using the trust mode
+// set by WithTrust (TrustTOFU by default) to decide whether to accept it.
+func NewWithKeyserver(keyringfile string, servers []string, opts ...Option) (*Signatory, error) {
+	ring, err := loadKeyRing(keyringfile)
+	if err != nil {
+		return nil, err
+	}
+
+	kkr := &keyserverKeyRing{
+		local:   ring,
+		servers: servers,
+		client:  http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(kkr)
+	}
+
+	return &Signatory{KeyRing: kkr}, nil
+}
+
+// keyserverKeyRing implements openpgp.KeyRing over a local EntityList,
+// falling back to one or more HKP(S) keyservers for keys it doesn't
+// recognize.
+type keyserverKeyRing struct {
+	local     openpgp.EntityList
+	servers   []string
+	client    *http.Client
+	cacheDir  string
+	trust     KeyserverTrust
+	tlsConfig *tls.Config
+}
+
+// KeysById implements openpgp.KeyRing.
+func (k *keyserverKeyRing) KeysById(id uint64) []openpgp.Key {
+	if keys := k.local.KeysById(id); len(keys) > 0 {
+		return keys
+	}
+	if entities, err := k.fetch(id); err == nil {
+		return entities.KeysById(id)
+	}
+	return nil
+}
+
+// KeysByIdUsage implements openpgp.KeyRing.
+func (k *keyserverKeyRing) KeysByIdUsage(id uint64, requiredUsage byte) []openpgp.Key {
+	if keys := k.local.KeysByIdUsage(id, requiredUsage); len(keys) > 0 {
+		return keys
+	}
+	if entities, err := k.fetch(id); err == nil {
+		return entities.KeysByIdUsage(id, requiredUsage)
+	}
+	return nil
+}
+
+// DecryptionKeys implements openpgp.KeyRing.
+func (k *keyserverKeyRing) DecryptionKeys() []openpgp.Key {
+	return k.local.DecryptionKeys()
+}
+
+// fetch retrieves the key with the given long key ID, preferring a cached
+// copy, and falling back to the configured keyservers in order. In
+// TrustStrict mode neither the cache nor the network is ever consulted: only
+// keys present in the keyring passed to NewWithKeyserver are trusted, since
+// the cache directory is no more trustworthy than the keyserver that filled
+// it (and may be writable by anything with filesystem access).
+func (k *keyserverKeyRing) fetch(id uint64) (openpgp.EntityList, error) {
+	if k.trust == TrustStrict {
+		return nil, fmt.Errorf("key 0x%016X not found in local keyring (strict trust mode, not consulting a keyserver cache or network)", id)
+	}
+
+	if cached, err := k.readCache(id); err == nil {
+		k.local = append(k.local, cached...)
+		return cached, nil
+	}
+
+	var lastErr error = fmt.Errorf("no keyservers configured")
+	for _, server := range k.servers {
+		entities, err := k.fetchFromServer(server, id)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		k.local = append(k.local, entities...)
+		k.writeCache(id, entities)
+		return entities, nil
+	}
+	return nil, lastErr
+}
+
+func (k *keyserverKeyRing) fetchFromServer(server string, id uint64) (openpgp.EntityList, error) {
+	url := strings.TrimRight(server, "/") + fmt.Sprintf("/pks/lookup?op=get&options=mr&search=0x%016X", id)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := k.client
+	if k.tlsConfig != nil {
+		transport := &http.Transport{TLSClientConfig: k.tlsConfig}
+		client = &http.Client{Transport: transport}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("keyserver %s returned %s for key 0x%016X", server, resp.Status, id)
+	}
+
+	block, err := armor.Decode(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("decoding response from keyserver %s: %s", server, err)
+	}
+	return openpgp.ReadKeyRing(block.Body)
+}
+
+func (k *keyserverKeyRing) cachePath(id uint64) string {
+	return filepath.Join(k.cacheDir, fmt.Sprintf("%016X.asc", id))
+}
+
+func (k *keyserverKeyRing) readCache(id uint64) (openpgp.EntityList, error) {
+	if k.cacheDir == "" {
+		return nil, errors.New("no keyserver cache directory configured")
+	}
+	f, err := os.Open(k.cachePath(id))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	block, err := armor.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	return openpgp.ReadKeyRing(block.Body)
+}
+
+// writeCache pins a fetched key to the cache directory. Failures are not
+// fatal: TOFU caching is a best-effort optimization, not a requirement for
+// verification to succeed.
+func (k *keyserverKeyRing) writeCache(id uint64, entities openpgp.EntityList) {
+	if k.cacheDir == "" {
+		return
+	}
+	if err := os.MkdirAll(k.cacheDir, 0755); err != nil {
+		return
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return
+	}
+	for _, e := range entities {
+		if err := e.Serialize(w); err != nil {
+			return
+		}
+	}
+	if err := w.Close(); err != nil {
+		return
+	}
+
+	ioutil.WriteFile(k.cachePath(id), buf.Bytes(), 0644)
+}
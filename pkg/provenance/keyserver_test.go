@@ -0,0 +1,140 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provenance
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// newKeyserverStub starts an HKP-like test server that serves e's public key
+// for any /pks/lookup?op=get request, and returns the server along with a
+// counter of how many requests it has received.
+func newKeyserverStub(t *testing.T, e *openpgp.Entity) (*httptest.Server, *int32) {
+	t.Helper()
+	var requests int32
+
+	buf := bytes.NewBuffer(nil)
+	w, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatalf("armoring test entity: %s", err)
+	}
+	if err := e.Serialize(w); err != nil {
+		t.Fatalf("serializing test entity: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("closing armor writer: %s", err)
+	}
+	armored := buf.Bytes()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write(armored)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &requests
+}
+
+func TestKeyserverKeyRingTOFU(t *testing.T) {
+	signer := newTestEntity(t, "tofu-signer")
+	ts, requests := newKeyserverStub(t, signer)
+
+	k := &keyserverKeyRing{
+		servers:  []string{ts.URL},
+		client:   http.DefaultClient,
+		cacheDir: t.TempDir(),
+		trust:    TrustTOFU,
+	}
+
+	entities, err := k.fetch(signer.PrimaryKey.KeyId)
+	if err != nil {
+		t.Fatalf("fetch (TOFU, unseen key): %s", err)
+	}
+	if len(entities) != 1 || entities[0].PrimaryKey.KeyId != signer.PrimaryKey.KeyId {
+		t.Fatalf("fetch (TOFU) returned %v, want the signer's key", entities)
+	}
+	if atomic.LoadInt32(requests) != 1 {
+		t.Fatalf("keyserver saw %d requests, want exactly 1", *requests)
+	}
+
+	cachePath := k.cachePath(signer.PrimaryKey.KeyId)
+	if _, err := os.Stat(cachePath); err != nil {
+		t.Errorf("TOFU fetch did not pin the key to %s: %s", cachePath, err)
+	}
+}
+
+func TestKeyserverKeyRingStrictRejectsUnseenKey(t *testing.T) {
+	signer := newTestEntity(t, "strict-signer")
+	ts, requests := newKeyserverStub(t, signer)
+
+	k := &keyserverKeyRing{
+		servers:  []string{ts.URL},
+		client:   http.DefaultClient,
+		cacheDir: t.TempDir(),
+		trust:    TrustStrict,
+	}
+
+	if _, err := k.fetch(signer.PrimaryKey.KeyId); err == nil {
+		t.Fatal("fetch (strict, unseen key) returned no error, want one")
+	}
+	if n := atomic.LoadInt32(requests); n != 0 {
+		t.Errorf("keyserver saw %d requests in strict mode, want 0 (network must never be consulted)", n)
+	}
+}
+
+func TestKeyserverKeyRingCachedKeyAvoidsSecondFetch(t *testing.T) {
+	signer := newTestEntity(t, "cached-signer")
+	ts, requests := newKeyserverStub(t, signer)
+	cacheDir := t.TempDir()
+
+	seed := &keyserverKeyRing{
+		servers:  []string{ts.URL},
+		client:   http.DefaultClient,
+		cacheDir: cacheDir,
+		trust:    TrustTOFU,
+	}
+	if _, err := seed.fetch(signer.PrimaryKey.KeyId); err != nil {
+		t.Fatalf("seeding cache: %s", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Fatalf("keyserver saw %d requests while seeding, want 1", n)
+	}
+
+	// A fresh keyserverKeyRing pointed at the same cache directory, but
+	// with no servers configured, can only succeed if it reads the cache
+	// rather than trying (and failing) to reach a keyserver.
+	cached := &keyserverKeyRing{
+		cacheDir: cacheDir,
+		trust:    TrustTOFU,
+	}
+	entities, err := cached.fetch(signer.PrimaryKey.KeyId)
+	if err != nil {
+		t.Fatalf("fetch (cached, no servers configured): %s", err)
+	}
+	if len(entities) != 1 || entities[0].PrimaryKey.KeyId != signer.PrimaryKey.KeyId {
+		t.Fatalf("fetch (cached) returned %v, want the signer's key", entities)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("keyserver saw %d requests after a cache hit, want still 1 (no second network fetch)", n)
+	}
+}
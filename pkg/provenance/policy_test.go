@@ -0,0 +1,179 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provenance
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+
+	hapi "k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+// testConfig keeps key generation fast; these keys only ever exist for the
+// lifetime of a test.
+var testConfig = &packet.Config{RSABits: 1024}
+
+func newTestEntity(t *testing.T, name string) *openpgp.Entity {
+	t.Helper()
+	e, err := openpgp.NewEntity(name, "", name+"@example.com", testConfig)
+	if err != nil {
+		t.Fatalf("generating test entity %q: %s", name, err)
+	}
+	return e
+}
+
+// newTestChart writes chartBytes to a temp file and returns its path and a
+// v2 Envelope whose Files section matches that file's sha256 sum, ready to
+// be passed to (*Signatory).SignEnvelope.
+func newTestChart(t *testing.T, chartBytes []byte) (string, *Envelope) {
+	t.Helper()
+	dir := t.TempDir()
+	chartpath := filepath.Join(dir, "test-0.1.0.tgz")
+	if err := ioutil.WriteFile(chartpath, chartBytes, 0644); err != nil {
+		t.Fatalf("writing test chart: %s", err)
+	}
+
+	sum, err := sumArchive(chartpath)
+	if err != nil {
+		t.Fatalf("summing test chart: %s", err)
+	}
+
+	env := &Envelope{
+		APIVersion: EnvelopeAPIVersion,
+		Metadata:   &hapi.Metadata{Name: "test", Version: "0.1.0"},
+		Files: map[string]string{
+			filepath.Base(chartpath): "sha256:" + sum,
+		},
+	}
+	return chartpath, env
+}
+
+// corruptLastSignature flips a byte inside the final "BEGIN PGP SIGNATURE"
+// block of a multi-signer provenance document, so the last signer's block
+// fails cryptographic verification while every earlier block is untouched.
+func corruptLastSignature(t *testing.T, doc string) string {
+	t.Helper()
+	const marker = "-----BEGIN PGP SIGNATURE-----"
+	idx := bytes.LastIndex([]byte(doc), []byte(marker))
+	if idx < 0 {
+		t.Fatal("no PGP SIGNATURE block found to corrupt")
+	}
+	data := []byte(doc)
+	// Walk forward past the marker and its trailing newline(s) into the
+	// base64 body, then flip one character there.
+	pos := idx + len(marker)
+	for data[pos] == '\n' || data[pos] == '\r' {
+		pos++
+	}
+	pos += 10 // a few characters into the base64 body
+	if data[pos] == 'A' {
+		data[pos] = 'B'
+	} else {
+		data[pos] = 'A'
+	}
+	return string(data)
+}
+
+func TestVerifyAllMultiSigner(t *testing.T) {
+	alice := newTestEntity(t, "alice")
+	bob := newTestEntity(t, "bob")
+	chartpath, env := newTestChart(t, []byte("fake chart contents"))
+
+	signer1 := &Signatory{Entity: alice}
+	doc, err := signer1.SignEnvelope(env)
+	if err != nil {
+		t.Fatalf("SignEnvelope (alice): %s", err)
+	}
+
+	provfile := filepath.Join(filepath.Dir(chartpath), "test-0.1.0.tgz.prov")
+	if err := ioutil.WriteFile(provfile, []byte(doc), 0644); err != nil {
+		t.Fatalf("writing provenance file: %s", err)
+	}
+
+	signer2 := &Signatory{Entity: bob}
+	combined, err := signer2.AddSignature(provfile)
+	if err != nil {
+		t.Fatalf("AddSignature (bob): %s", err)
+	}
+	if err := ioutil.WriteFile(provfile, []byte(combined), 0644); err != nil {
+		t.Fatalf("rewriting provenance file: %s", err)
+	}
+
+	verifier := &Signatory{KeyRing: openpgp.EntityList{alice, bob}}
+
+	t.Run("both co-signers valid", func(t *testing.T) {
+		vers, err := verifier.VerifyAll(chartpath, provfile)
+		if err != nil {
+			t.Fatalf("VerifyAll: %s", err)
+		}
+		if len(vers) != 2 {
+			t.Fatalf("VerifyAll returned %d verifications, want 2", len(vers))
+		}
+	})
+
+	t.Run("MinValid satisfied by the two valid signers", func(t *testing.T) {
+		matching, err := verifier.VerifyPolicy(chartpath, provfile, VerificationPolicy{MinValid: 2})
+		if err != nil {
+			t.Fatalf("VerifyPolicy: %s", err)
+		}
+		if len(matching) != 2 {
+			t.Errorf("VerifyPolicy returned %d matching, want 2", len(matching))
+		}
+	})
+
+	t.Run("VerifyPolicy restricted to a specific key ID", func(t *testing.T) {
+		aliceID := fmt.Sprintf("%X", alice.PrimaryKey.Fingerprint[12:])
+		matching, err := verifier.VerifyPolicy(chartpath, provfile, VerificationPolicy{
+			RequiredKeyIDs: []string{aliceID},
+			MinValid:       1,
+		})
+		if err != nil {
+			t.Fatalf("VerifyPolicy: %s", err)
+		}
+		if len(matching) != 1 || matching[0].SignedBy != alice {
+			t.Errorf("VerifyPolicy(RequiredKeyIDs=alice) = %v, want exactly alice's verification", matching)
+		}
+	})
+
+	t.Run("one co-signer's block is tampered", func(t *testing.T) {
+		tampered := corruptLastSignature(t, combined)
+		tamperedFile := filepath.Join(filepath.Dir(chartpath), "tampered.tgz.prov")
+		if err := ioutil.WriteFile(tamperedFile, []byte(tampered), 0644); err != nil {
+			t.Fatalf("writing tampered provenance file: %s", err)
+		}
+
+		vers, err := verifier.VerifyAll(chartpath, tamperedFile)
+		if err != nil {
+			t.Fatalf("VerifyAll (tampered): %s", err)
+		}
+		if len(vers) != 1 {
+			t.Fatalf("VerifyAll (tampered) returned %d verifications, want 1 (only alice's block should survive)", len(vers))
+		}
+		if vers[0].SignedBy != alice {
+			t.Errorf("VerifyAll (tampered) kept the wrong signer: %v", vers[0].SignedBy)
+		}
+
+		if _, err := verifier.VerifyPolicy(chartpath, tamperedFile, VerificationPolicy{MinValid: 2}); err == nil {
+			t.Error("VerifyPolicy(MinValid: 2) returned no error with only one valid signer left, want one")
+		}
+	})
+}
@@ -26,6 +26,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/ghodss/yaml"
 
@@ -60,6 +61,36 @@ type Verification struct {
 	SignedBy *openpgp.Entity
 	// FileHash is the hash, prepended with the scheme, for the file that was verified.
 	FileHash string
+	// SignedAt is the creation time embedded in the signature itself.
+	SignedAt time.Time
+	// KeyExpired reports whether SignedBy's signing key had already expired
+	// at SignedAt.
+	KeyExpired bool
+	// KeyRevoked reports whether SignedBy's signing key carries a
+	// revocation signature in the keyring used to verify it.
+	KeyRevoked bool
+}
+
+// VerifyOptions controls how strictly Verify (and VerifyAll, VerifyPolicy,
+// VerifyEnvelope) check the signing key itself, beyond the signature's bare
+// cryptographic validity.
+//
+// The zero value is strict: a signature made with a key that had already
+// expired when it signed, or with a revoked key, is rejected. A key that
+// expired only after the signature was made is not rejected. This is the default for `helm install --verify`;
+// passing --verify-allow-expired sets AllowExpiredKey and AllowRevokedKey to
+// restore the previous, lax behavior.
+//
+// golang.org/x/crypto/openpgp never parses RFC 4880 trust signature
+// subpackets, so there is no TrustLevel to check here; a minimum-trust
+// requirement would have nothing to read.
+type VerifyOptions struct {
+	// AllowExpiredKey, if true, accepts a signature even though the signing
+	// key had already expired when the signature was made.
+	AllowExpiredKey bool
+	// AllowRevokedKey, if true, accepts a signature from a key that carries
+	// a revocation signature in the keyring.
+	AllowRevokedKey bool
 }
 
 // Signatory signs things.
@@ -73,7 +104,24 @@ type Signatory struct {
 	// The signatory for this instance of Helm. This is used for signing.
 	Entity *openpgp.Entity
 	// The keyring for this instance of Helm. This is used for verification.
-	KeyRing openpgp.EntityList
+	//
+	// This is usually an openpgp.EntityList loaded from a local keyring file,
+	// but it can be any openpgp.KeyRing, including a keyserverKeyRing from
+	// NewWithKeyserver that fetches unknown keys on demand.
+	KeyRing openpgp.KeyRing
+	// ImageResolver resolves container image references to registry content
+	// digests. If set, ClearSign records a digest for every image referenced
+	// in the chart's templates, and (when VerifyImages is true) Verify
+	// re-resolves and checks them. If nil, image signing/verification is
+	// skipped entirely, preserving the previous behavior.
+	ImageResolver ImageResolver
+	// VerifyImages, if true, tells Verify to re-resolve each image digest
+	// recorded in the provenance file and fail if a current digest disagrees
+	// with the signed one. Requires ImageResolver to be set.
+	VerifyImages bool
+	// VerifyOptions controls how strictly Verify checks the signing key
+	// itself (expiration, revocation). The zero value is strict.
+	VerifyOptions VerifyOptions
 }
 
 // NewFromFiles constructs a new Signatory from the PGP key in the given filename.
@@ -106,7 +154,8 @@ func NewFromFiles(keyfile, keyringfile string) (*Signatory, error) {
 //
 // If id is not the empty string, this will also try to find an Entity in the
 // keyring whose name matches, and set that as the signing entity. It will return
-// an error if the id is not empty and also not found.
+// an error if the id is not empty and also not found, or if the resolved entity
+// has no signing-capable private key (see (*Signatory).signingKey).
 func NewFromKeyring(keyringfile, id string) (*Signatory, error) {
 	ring, err := loadKeyRing(keyringfile)
 	if err != nil {
@@ -129,6 +178,9 @@ func NewFromKeyring(keyringfile, id string) (*Signatory, error) {
 		for n := range e.Identities {
 			if n == id {
 				s.Entity = e
+				if _, err := s.signingKey(); err != nil {
+					return s, fmt.Errorf("key %q has no signing-capable key material: %s", id, err)
+				}
 				return s, nil
 			}
 			if strings.Contains(n, id) {
@@ -142,7 +194,13 @@ func NewFromKeyring(keyringfile, id string) (*Signatory, error) {
 	if vague {
 		return s, fmt.Errorf("more than one key contain the id %q", id)
 	}
+	if candidate == nil {
+		return s, fmt.Errorf("no key found matching %q", id)
+	}
 	s.Entity = candidate
+	if _, err := s.signingKey(); err != nil {
+		return s, fmt.Errorf("key %q has no signing-capable key material: %s", id, err)
+	}
 	return s, nil
 }
 
@@ -150,11 +208,13 @@ func NewFromKeyring(keyringfile, id string) (*Signatory, error) {
 //
 // This takes the path to a chart archive file and a key, and it returns a clear signature.
 //
-// The Signatory must have a valid Entity.PrivateKey for this to work. If it does
-// not, an error will be returned.
+// The Signatory must have a valid signing key, either on Entity.PrivateKey or on
+// one of Entity.Subkeys marked sign-capable (see (*Signatory).signingKey). If
+// neither is available, an error will be returned.
 func (s *Signatory) ClearSign(chartpath string) (string, error) {
-	if s.Entity.PrivateKey == nil {
-		return "", errors.New("private key not found")
+	key, err := s.signingKey()
+	if err != nil {
+		return "", err
 	}
 
 	if fi, err := os.Stat(chartpath); err != nil {
@@ -165,13 +225,13 @@ func (s *Signatory) ClearSign(chartpath string) (string, error) {
 
 	out := bytes.NewBuffer(nil)
 
-	b, err := messageBlock(chartpath)
+	b, err := s.messageBlock(chartpath)
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 
 	// Sign the buffer
-	w, err := clearsign.Encode(out, s.Entity.PrivateKey, &defaultPGPConfig)
+	w, err := clearsign.Encode(out, key, &defaultPGPConfig)
 	if err != nil {
 		return "", err
 	}
@@ -180,35 +240,240 @@ func (s *Signatory) ClearSign(chartpath string) (string, error) {
 	return out.String(), err
 }
 
+// AddSignature signs the same content as an already-signed provenance file
+// with this Signatory's key, and returns a combined provenance document
+// carrying both the existing signature block(s) and the new one.
+//
+// This is how a chart gets signed by more than one party (e.g. a developer,
+// a security reviewer, and a release manager): each signer calls AddSignature
+// in turn, passing along the previous signer's output. Existing single-block
+// .prov files remain readable by Verify, which only inspects the first
+// block; use VerifyAll or VerifyPolicy to check every signer.
+func (s *Signatory) AddSignature(existingProv string) (string, error) {
+	key, err := s.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := ioutil.ReadFile(existingProv)
+	if err != nil {
+		return "", err
+	}
+
+	block, _ := clearsign.Decode(data)
+	if block == nil {
+		return "", errors.New("signature block not found")
+	}
+
+	out := bytes.NewBuffer(nil)
+	w, err := clearsign.Encode(out, key, &defaultPGPConfig)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(block.Plaintext); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	combined := bytes.NewBuffer(data)
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		combined.WriteString("\n")
+	}
+	combined.Write(out.Bytes())
+	return combined.String(), nil
+}
+
+// signingKey returns the private key that should be used to sign with s.Entity.
+//
+// GnuPG keyrings commonly delegate signing to a subkey marked with
+// packet.KeyFlagSign, keeping the primary key restricted to certification
+// (packet.KeyFlagCertify). This walks s.Entity.Subkeys looking for the first
+// subkey whose self-signature is valid and marks it sign-capable, skipping
+// subkeys that are expired or have been revoked. If no such subkey exists, it
+// falls back to the primary key, but only if the primary key itself is marked
+// sign-capable.
+func (s *Signatory) signingKey() (*packet.PrivateKey, error) {
+	if s.Entity == nil {
+		return nil, errors.New("private key not found")
+	}
+
+	now := time.Now()
+	for _, sub := range s.Entity.Subkeys {
+		if sub.PrivateKey == nil || sub.Sig == nil {
+			continue
+		}
+		if !sub.Sig.FlagsValid || !sub.Sig.FlagSign {
+			continue
+		}
+		if sub.Sig.SigType == packet.SigTypeSubkeyRevocation {
+			continue
+		}
+		if sub.Sig.KeyExpired(now) {
+			continue
+		}
+		return sub.PrivateKey, nil
+	}
+
+	if s.Entity.PrivateKey == nil {
+		return nil, errors.New("private key not found")
+	}
+
+	ident := primaryIdentity(s.Entity)
+	if ident == nil || ident.SelfSignature == nil || !ident.SelfSignature.FlagsValid || !ident.SelfSignature.FlagSign {
+		return nil, errors.New("primary key is not marked for signing, and no usable signing subkey was found")
+	}
+
+	return s.Entity.PrivateKey, nil
+}
+
+// primaryIdentity returns the self-signed identity that openpgp treats as primary.
+func primaryIdentity(e *openpgp.Entity) *openpgp.Identity {
+	var ident *openpgp.Identity
+	for _, id := range e.Identities {
+		if ident == nil || (id.SelfSignature != nil && id.SelfSignature.IsPrimaryId != nil && *id.SelfSignature.IsPrimaryId) {
+			ident = id
+		}
+	}
+	return ident
+}
+
 // Verify checks a signature and verifies that it is legit for a chart.
+//
+// If sigpath holds more than one signature block (see AddSignature), Verify
+// checks only the first one. Use VerifyAll to check every signer, or
+// VerifyPolicy to enforce a multi-signer threshold.
 func (s *Signatory) Verify(chartpath, sigpath string) (*Verification, error) {
-	ver := &Verification{}
-	for _, fname := range []string{chartpath, sigpath} {
-		if fi, err := os.Stat(fname); err != nil {
-			return ver, err
-		} else if fi.IsDir() {
-			return ver, fmt.Errorf("%s cannot be a directory", fname)
-		}
+	if err := checkNotDir(chartpath, sigpath); err != nil {
+		return &Verification{}, err
 	}
 
-	// First verify the signature
 	sig, err := s.decodeSignature(sigpath)
 	if err != nil {
-		return ver, fmt.Errorf("failed to decode signature: %s", err)
+		return &Verification{}, fmt.Errorf("failed to decode signature: %s", err)
+	}
+
+	return s.verifyBlock(chartpath, sig)
+}
+
+// VerifyAll checks every signature block found in sigpath and returns a
+// Verification for each one that is cryptographically valid and matches
+// chartpath's hash.
+//
+// Unlike Verify, a single invalid or unrecognized signer does not abort the
+// whole operation: that block is simply omitted from the result. VerifyAll
+// only fails outright if no block in sigpath verifies at all, or if the
+// file's underlying sums cannot be parsed.
+func (s *Signatory) VerifyAll(chartpath, sigpath string) ([]*Verification, error) {
+	if err := checkNotDir(chartpath, sigpath); err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(sigpath)
+	if err != nil {
+		return nil, err
+	}
+
+	var vers []*Verification
+	rest := data
+	for {
+		var block *clearsign.Block
+		block, rest = clearsign.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		ver, err := s.verifyBlock(chartpath, block)
+		if err != nil {
+			// This signer either isn't in our keyring or its sum doesn't
+			// match; a multi-signer file may legitimately carry signatures
+			// we can't verify locally, so skip rather than fail outright.
+			continue
+		}
+		vers = append(vers, ver)
+	}
+
+	if len(vers) == 0 {
+		return nil, errors.New("no valid signatures found in " + sigpath)
+	}
+	return vers, nil
+}
+
+// VerificationPolicy describes a multi-signer requirement: at least MinValid
+// of the keys in RequiredKeyIDs must have produced a valid signature over the
+// chart.
+type VerificationPolicy struct {
+	// RequiredKeyIDs restricts which signers count toward the policy, given
+	// as hex-encoded OpenPGP long key IDs (the last 16 hex digits of the
+	// fingerprint). A nil or empty slice means any valid signer counts.
+	RequiredKeyIDs []string
+	// MinValid is the minimum number of matching, valid signatures required.
+	MinValid int
+}
+
+// VerifyPolicy verifies every signature block in sigpath and checks the
+// result against policy. It returns the Verifications that counted toward
+// the policy, and an error if fewer than policy.MinValid of them were found.
+func (s *Signatory) VerifyPolicy(chartpath, sigpath string, policy VerificationPolicy) ([]*Verification, error) {
+	all, err := s.VerifyAll(chartpath, sigpath)
+	if err != nil {
+		return nil, err
 	}
 
-	by, err := s.verifySignature(sig)
+	var matching []*Verification
+	for _, v := range all {
+		if len(policy.RequiredKeyIDs) == 0 || keyIDMatchesAny(v.SignedBy, policy.RequiredKeyIDs) {
+			matching = append(matching, v)
+		}
+	}
+
+	if len(matching) < policy.MinValid {
+		return matching, fmt.Errorf("provenance policy requires %d valid signature(s), found %d", policy.MinValid, len(matching))
+	}
+	return matching, nil
+}
+
+// keyIDMatchesAny reports whether e's long key ID is in ids.
+func keyIDMatchesAny(e *openpgp.Entity, ids []string) bool {
+	if e == nil || e.PrimaryKey == nil {
+		return false
+	}
+	id := fmt.Sprintf("%X", e.PrimaryKey.Fingerprint[12:])
+	for _, want := range ids {
+		if strings.EqualFold(strings.TrimPrefix(strings.TrimPrefix(want, "0x"), "0X"), id) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyBlock checks a single clearsign block's signature and the chart hash
+// (and, if requested, image digests) it attests to.
+func (s *Signatory) verifyBlock(chartpath string, block *clearsign.Block) (*Verification, error) {
+	ver := &Verification{}
+
+	by, sig, err := s.checkSignature(block)
 	if err != nil {
 		return ver, err
 	}
 	ver.SignedBy = by
+	ver.SignedAt = sig.CreationTime
+	ver.KeyExpired = keyExpiredAt(by, issuerKeyID(sig), sig.CreationTime)
+	ver.KeyRevoked = keyRevoked(by, issuerKeyID(sig))
+
+	if ver.KeyExpired && !s.VerifyOptions.AllowExpiredKey {
+		return ver, fmt.Errorf("signature from %s was made with a key that had already expired", entityName(by))
+	}
+	if ver.KeyRevoked && !s.VerifyOptions.AllowRevokedKey {
+		return ver, fmt.Errorf("signature from %s was made with a revoked key", entityName(by))
+	}
 
-	// Second, verify the hash of the tarball.
 	sum, err := sumArchive(chartpath)
 	if err != nil {
 		return ver, err
 	}
-	_, sums, err := parseMessageBlock(sig.Plaintext)
+	_, sums, err := parseMessageBlock(block.Plaintext)
 	if err != nil {
 		return ver, err
 	}
@@ -222,11 +487,36 @@ func (s *Signatory) Verify(chartpath, sigpath string) (*Verification, error) {
 	}
 	ver.FileHash = sum
 
-	// TODO: when image signing is added, verify that here.
+	if s.VerifyImages && len(sums.Images) > 0 {
+		if s.ImageResolver == nil {
+			return ver, errors.New("provenance contains signed image digests, but no ImageResolver was configured to verify them")
+		}
+		for ref, signed := range sums.Images {
+			current, err := s.ImageResolver.ResolveDigest(ref)
+			if err != nil {
+				return ver, fmt.Errorf("resolving current digest for %s: %s", ref, err)
+			}
+			if current != signed {
+				return ver, fmt.Errorf("image digest does not match for %s: %q != %q", ref, signed, current)
+			}
+		}
+	}
 
 	return ver, nil
 }
 
+// checkNotDir verifies that every given path exists and is a regular file.
+func checkNotDir(paths ...string) error {
+	for _, fname := range paths {
+		if fi, err := os.Stat(fname); err != nil {
+			return err
+		} else if fi.IsDir() {
+			return fmt.Errorf("%s cannot be a directory", fname)
+		}
+	}
+	return nil
+}
+
 func (s *Signatory) decodeSignature(filename string) (*clearsign.Block, error) {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -242,60 +532,138 @@ func (s *Signatory) decodeSignature(filename string) (*clearsign.Block, error) {
 	return block, nil
 }
 
-// verifySignature verifies that the given block is validly signed, and returns the signer.
-func (s *Signatory) verifySignature(block *clearsign.Block) (*openpgp.Entity, error) {
-	return openpgp.CheckDetachedSignature(
-		s.KeyRing,
-		bytes.NewBuffer(block.Bytes),
-		block.ArmoredSignature.Body,
-	)
-}
+// checkSignature verifies that block is validly signed, and returns both the
+// signer and the signature packet itself, which carries the metadata (issuer
+// key ID, creation time) needed to check the signing key's expiration and
+// revocation.
+func (s *Signatory) checkSignature(block *clearsign.Block) (*openpgp.Entity, *packet.Signature, error) {
+	sigBytes, err := ioutil.ReadAll(block.ArmoredSignature.Body)
+	if err != nil {
+		return nil, nil, err
+	}
 
-func messageBlock(chartpath string) (*bytes.Buffer, error) {
-	var b *bytes.Buffer
-	// Checksum the archive
-	chash, err := sumArchive(chartpath)
+	pkt, err := packet.Read(bytes.NewReader(sigBytes))
 	if err != nil {
-		return b, err
+		return nil, nil, err
+	}
+	sig, ok := pkt.(*packet.Signature)
+	if !ok {
+		return nil, nil, errors.New("expected an OpenPGP signature packet")
 	}
 
-	base := filepath.Base(chartpath)
-	sums := &SumCollection{
-		Files: map[string]string{
-			base: "sha256:" + chash,
-		},
+	by, err := openpgp.CheckDetachedSignature(s.KeyRing, bytes.NewReader(block.Bytes), bytes.NewReader(sigBytes))
+	if err != nil {
+		return nil, nil, err
 	}
+	return by, sig, nil
+}
 
-	// Load the archive into memory.
-	chart, err := chartutil.LoadFile(chartpath)
+// issuerKeyID returns the key ID that produced sig, or 0 if the signature
+// carries none (which should not happen for signatures Helm produces).
+func issuerKeyID(sig *packet.Signature) uint64 {
+	if sig.IssuerKeyId != nil {
+		return *sig.IssuerKeyId
+	}
+	return 0
+}
+
+// keyExpiredAt reports whether the key (primary or subkey) in e identified
+// by id had expired by the time at, per its self-signature's key lifetime.
+func keyExpiredAt(e *openpgp.Entity, id uint64, at time.Time) bool {
+	if e.PrimaryKey != nil && e.PrimaryKey.KeyId == id {
+		ident := primaryIdentity(e)
+		if ident == nil || ident.SelfSignature == nil {
+			return false
+		}
+		return ident.SelfSignature.KeyExpired(at)
+	}
+	for _, sub := range e.Subkeys {
+		if sub.PublicKey != nil && sub.PublicKey.KeyId == id {
+			return sub.Sig != nil && sub.Sig.KeyExpired(at)
+		}
+	}
+	return false
+}
+
+// keyRevoked reports whether the key (primary or subkey) in e identified by
+// id has been revoked.
+//
+// A primary-key revocation is a SigTypeKeyRevocation signature stored in
+// Entity.Revocations, never in an Identity's SelfSignature (which only ever
+// holds a SigTypePositiveCert/SigTypeGenericCert certification). A revoked
+// subkey's Sig instead carries SigTypeSubkeyRevocation.
+func keyRevoked(e *openpgp.Entity, id uint64) bool {
+	if e.PrimaryKey != nil && e.PrimaryKey.KeyId == id {
+		return len(e.Revocations) > 0
+	}
+	for _, sub := range e.Subkeys {
+		if sub.PublicKey != nil && sub.PublicKey.KeyId == id {
+			return sub.Sig != nil && sub.Sig.SigType == packet.SigTypeSubkeyRevocation
+		}
+	}
+	return false
+}
+
+// entityName returns a human-readable label for e, for use in error messages.
+func entityName(e *openpgp.Entity) string {
+	if ident := primaryIdentity(e); ident != nil {
+		return ident.Name
+	}
+	if e.PrimaryKey != nil {
+		return fmt.Sprintf("key 0x%X", e.PrimaryKey.KeyId)
+	}
+	return "unknown signer"
+}
+
+// messageBlock builds the payload that gets clearsigned for chartpath, as a
+// v2 Envelope (see envelope.go).
+func (s *Signatory) messageBlock(chartpath string) (*bytes.Buffer, error) {
+	chash, err := sumArchive(chartpath)
 	if err != nil {
-		return b, err
+		return nil, err
 	}
 
-	// Buffer a hash + checksums YAML file
-	data, err := yaml.Marshal(chart.Metadata)
+	// Load the archive into memory.
+	chart, err := chartutil.LoadFile(chartpath)
 	if err != nil {
-		return b, err
+		return nil, err
 	}
 
-	// FIXME: YAML uses ---\n as a file start indicator, but this is not legal in a PGP
-	// clearsign block. So we use ...\n, which is the YAML document end marker.
-	// http://yaml.org/spec/1.2/spec.html#id2800168
-	b = bytes.NewBuffer(data)
-	b.WriteString("\n...\n")
+	base := filepath.Base(chartpath)
+	env := &Envelope{
+		APIVersion: EnvelopeAPIVersion,
+		Metadata:   chart.Metadata,
+		Files: map[string]string{
+			base: "sha256:" + chash,
+		},
+	}
 
-	data, err = yaml.Marshal(sums)
-	if err != nil {
-		return b, err
+	if s.ImageResolver != nil {
+		images, err := resolveImageSums(s.ImageResolver, templateManifests(chart))
+		if err != nil {
+			return nil, err
+		}
+		env.Images = images
 	}
-	b.Write(data)
 
-	return b, nil
+	return encodeEnvelope(env)
 }
 
-// parseMessageBlock
+// parseMessageBlock reads the payload of a clearsigned provenance block.
+//
+// It dispatches on the payload's apiVersion: v2 envelopes (see envelope.go)
+// are decoded directly, while payloads with no apiVersion are assumed to be
+// the legacy v1 format, where a YAML-encoded chart.Metadata document and a
+// YAML-encoded SumCollection document are separated by a literal "\n...\n"
+// (the YAML document-end marker, used in place of "---\n" because that
+// sequence is illegal inside a PGP clearsign block). v1 payloads are still
+// read so that provenance files signed before the v2 envelope was introduced
+// continue to verify; new signatures are always written as v2.
 func parseMessageBlock(data []byte) (*hapi.Metadata, *SumCollection, error) {
-	// This sucks.
+	if env, err := decodeEnvelope(data); err == nil {
+		return env.Metadata, &SumCollection{Files: env.Files, Images: env.Images}, nil
+	}
+
 	parts := bytes.Split(data, []byte("\n...\n"))
 	if len(parts) < 2 {
 		return nil, nil, errors.New("message block must have at least two parts")
@@ -311,6 +679,23 @@ func parseMessageBlock(data []byte) (*hapi.Metadata, *SumCollection, error) {
 	return md, sc, err
 }
 
+// templateManifests returns the chart's raw template sources keyed by name,
+// suitable for scanning with ExtractImages. Helm signs at package time,
+// before templates are rendered against a release, so this intentionally
+// scans the template source rather than a rendered manifest. This only
+// finds a real image reference for charts whose image fields are static;
+// a templated field (e.g. `image: "{{ .Values.image.repository }}:{{
+// .Values.image.tag }}"`) is caught and reported by resolveImageSums
+// instead of silently mis-resolving. Packagers that need digests for a
+// templated chart can pre-render and repack it before signing.
+func templateManifests(chart *hapi.Chart) map[string]string {
+	manifests := make(map[string]string, len(chart.Templates))
+	for _, tpl := range chart.Templates {
+		manifests[tpl.Name] = string(tpl.Data)
+	}
+	return manifests
+}
+
 // loadKey loads a GPG key found at a particular path.
 func loadKey(keypath string) (*openpgp.Entity, error) {
 	f, err := os.Open(keypath)
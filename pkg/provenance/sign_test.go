@@ -0,0 +1,241 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provenance
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// These tests exercise signingKey, keyExpiredAt and keyRevoked against
+// hand-built fixture entities rather than real keyrings: all three functions
+// only ever inspect fields already present on an *openpgp.Entity, so there
+// is nothing to gain from parsing real key material.
+
+func lifetimeSecs(secs uint32) *uint32 {
+	return &secs
+}
+
+func signCapableSig() *packet.Signature {
+	return &packet.Signature{
+		CreationTime: time.Now().Add(-time.Hour),
+		FlagsValid:   true,
+		FlagSign:     true,
+	}
+}
+
+func TestSigningKey(t *testing.T) {
+	primaryPriv := &packet.PrivateKey{PublicKey: packet.PublicKey{KeyId: 1}}
+	subPriv := &packet.PrivateKey{PublicKey: packet.PublicKey{KeyId: 2}}
+
+	t.Run("prefers a sign-capable subkey over the primary key", func(t *testing.T) {
+		e := &openpgp.Entity{
+			PrimaryKey: &packet.PublicKey{KeyId: 1},
+			PrivateKey: primaryPriv,
+			Subkeys: []openpgp.Subkey{
+				{PublicKey: &packet.PublicKey{KeyId: 2}, PrivateKey: subPriv, Sig: signCapableSig()},
+			},
+		}
+		s := &Signatory{Entity: e}
+		got, err := s.signingKey()
+		if err != nil {
+			t.Fatalf("signingKey() returned error: %s", err)
+		}
+		if got != subPriv {
+			t.Errorf("signingKey() = %v, want the sign-capable subkey", got)
+		}
+	})
+
+	t.Run("skips a subkey whose self-signature is not marked for signing", func(t *testing.T) {
+		e := &openpgp.Entity{
+			PrimaryKey: &packet.PublicKey{KeyId: 1},
+			PrivateKey: primaryPriv,
+			Identities: map[string]*openpgp.Identity{
+				"test": {SelfSignature: signCapableSig()},
+			},
+			Subkeys: []openpgp.Subkey{
+				{PublicKey: &packet.PublicKey{KeyId: 2}, PrivateKey: subPriv, Sig: &packet.Signature{
+					CreationTime: time.Now(),
+					FlagsValid:   true,
+					FlagSign:     false,
+				}},
+			},
+		}
+		s := &Signatory{Entity: e}
+		got, err := s.signingKey()
+		if err != nil {
+			t.Fatalf("signingKey() returned error: %s", err)
+		}
+		if got != primaryPriv {
+			t.Errorf("signingKey() = %v, want fallback to the primary key", got)
+		}
+	})
+
+	t.Run("skips a revoked signing subkey", func(t *testing.T) {
+		revoked := signCapableSig()
+		revoked.SigType = packet.SigTypeSubkeyRevocation
+		e := &openpgp.Entity{
+			PrimaryKey: &packet.PublicKey{KeyId: 1},
+			PrivateKey: primaryPriv,
+			Identities: map[string]*openpgp.Identity{
+				"test": {SelfSignature: signCapableSig()},
+			},
+			Subkeys: []openpgp.Subkey{
+				{PublicKey: &packet.PublicKey{KeyId: 2}, PrivateKey: subPriv, Sig: revoked},
+			},
+		}
+		s := &Signatory{Entity: e}
+		got, err := s.signingKey()
+		if err != nil {
+			t.Fatalf("signingKey() returned error: %s", err)
+		}
+		if got != primaryPriv {
+			t.Errorf("signingKey() = %v, want fallback to the primary key (subkey is revoked)", got)
+		}
+	})
+
+	t.Run("skips an expired signing subkey", func(t *testing.T) {
+		expired := &packet.Signature{
+			CreationTime:    time.Now().Add(-48 * time.Hour),
+			KeyLifetimeSecs: lifetimeSecs(3600),
+			FlagsValid:      true,
+			FlagSign:        true,
+		}
+		e := &openpgp.Entity{
+			PrimaryKey: &packet.PublicKey{KeyId: 1},
+			PrivateKey: primaryPriv,
+			Identities: map[string]*openpgp.Identity{
+				"test": {SelfSignature: signCapableSig()},
+			},
+			Subkeys: []openpgp.Subkey{
+				{PublicKey: &packet.PublicKey{KeyId: 2}, PrivateKey: subPriv, Sig: expired},
+			},
+		}
+		s := &Signatory{Entity: e}
+		got, err := s.signingKey()
+		if err != nil {
+			t.Fatalf("signingKey() returned error: %s", err)
+		}
+		if got != primaryPriv {
+			t.Errorf("signingKey() = %v, want fallback to the primary key (subkey is expired)", got)
+		}
+	})
+
+	t.Run("errors when neither the primary key nor any subkey can sign", func(t *testing.T) {
+		e := &openpgp.Entity{
+			PrimaryKey: &packet.PublicKey{KeyId: 1},
+			PrivateKey: primaryPriv,
+			Identities: map[string]*openpgp.Identity{
+				"test": {SelfSignature: &packet.Signature{FlagsValid: true, FlagSign: false}},
+			},
+		}
+		s := &Signatory{Entity: e}
+		if _, err := s.signingKey(); err == nil {
+			t.Error("signingKey() returned no error, want one (primary key not marked for signing)")
+		}
+	})
+}
+
+func TestKeyExpiredAt(t *testing.T) {
+	now := time.Now()
+
+	isPrimary := true
+	primary := &packet.PublicKey{KeyId: 1}
+	entity := &openpgp.Entity{
+		PrimaryKey: primary,
+		Identities: map[string]*openpgp.Identity{
+			"test": {SelfSignature: &packet.Signature{
+				CreationTime:    now.Add(-48 * time.Hour),
+				KeyLifetimeSecs: lifetimeSecs(3600),
+				IsPrimaryId:     &isPrimary,
+			}},
+		},
+		Subkeys: []openpgp.Subkey{
+			{PublicKey: &packet.PublicKey{KeyId: 2}, Sig: &packet.Signature{
+				CreationTime:    now,
+				KeyLifetimeSecs: lifetimeSecs(3600),
+			}},
+		},
+	}
+
+	if !keyExpiredAt(entity, 1, now) {
+		t.Error("keyExpiredAt(primary) = false, want true")
+	}
+	if keyExpiredAt(entity, 2, now) {
+		t.Error("keyExpiredAt(subkey) = true, want false (not yet expired)")
+	}
+	if keyExpiredAt(entity, 99, now) {
+		t.Error("keyExpiredAt(unknown key ID) = true, want false")
+	}
+}
+
+func TestKeyRevoked(t *testing.T) {
+	t.Run("primary key revocation lives in Entity.Revocations", func(t *testing.T) {
+		e := &openpgp.Entity{
+			PrimaryKey:  &packet.PublicKey{KeyId: 1},
+			Revocations: []*packet.Signature{{SigType: packet.SigTypeKeyRevocation}},
+		}
+		if !keyRevoked(e, 1) {
+			t.Error("keyRevoked(primary) = false, want true")
+		}
+	})
+
+	t.Run("primary key is not revoked just because an identity has a self-signature", func(t *testing.T) {
+		e := &openpgp.Entity{
+			PrimaryKey: &packet.PublicKey{KeyId: 1},
+			Identities: map[string]*openpgp.Identity{
+				"test": {SelfSignature: &packet.Signature{SigType: packet.SigTypePositiveCert}},
+			},
+		}
+		if keyRevoked(e, 1) {
+			t.Error("keyRevoked(primary) = true, want false (no entry in Revocations)")
+		}
+	})
+
+	t.Run("revoked subkey carries SigTypeSubkeyRevocation on Sig", func(t *testing.T) {
+		e := &openpgp.Entity{
+			PrimaryKey: &packet.PublicKey{KeyId: 1},
+			Subkeys: []openpgp.Subkey{
+				{PublicKey: &packet.PublicKey{KeyId: 2}, Sig: &packet.Signature{SigType: packet.SigTypeSubkeyRevocation}},
+			},
+		}
+		if !keyRevoked(e, 2) {
+			t.Error("keyRevoked(subkey) = false, want true")
+		}
+	})
+
+	t.Run("non-revoked subkey", func(t *testing.T) {
+		e := &openpgp.Entity{
+			PrimaryKey: &packet.PublicKey{KeyId: 1},
+			Subkeys: []openpgp.Subkey{
+				{PublicKey: &packet.PublicKey{KeyId: 2}, Sig: &packet.Signature{SigType: packet.SigTypeSubkeyBinding}},
+			},
+		}
+		if keyRevoked(e, 2) {
+			t.Error("keyRevoked(subkey) = true, want false")
+		}
+	})
+
+	t.Run("unknown key ID", func(t *testing.T) {
+		e := &openpgp.Entity{PrimaryKey: &packet.PublicKey{KeyId: 1}}
+		if keyRevoked(e, 99) {
+			t.Error("keyRevoked(unknown key ID) = true, want false")
+		}
+	})
+}